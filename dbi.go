@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"reflect"
 	"regexp"
 	"strings"
 )
@@ -31,8 +32,18 @@ type Config struct {
 	// Maximum open connections
 	MaxOpenConns int `yaml:"maxOpenConns"`
 	// Maximum idle connections
-	MaxIdleConns int     `yaml:"maxIdleConns"`
-	db           *sql.DB `yaml:"-"        json:"-"`
+	MaxIdleConns int `yaml:"maxIdleConns"`
+	// RetryPolicy, when set, makes MultiQuery, Upsert, Transaction
+	// and Unmarshal (and their WithOptions variants) automatically
+	// retry from scratch on a serializable isolation conflict or a
+	// detected deadlock. A nil RetryPolicy disables retrying.
+	RetryPolicy *RetryPolicy `yaml:"-"        json:"-"`
+	// ArrayStringifyMode controls how QueryString renders
+	// array-valued columns. Defaults to StringifyCSV.
+	ArrayStringifyMode StringifyMode                             `yaml:"-" json:"-"`
+	db                 *sql.DB                                   `yaml:"-" json:"-"`
+	hook               QueryHook                                 `yaml:"-" json:"-"`
+	stringifiers       map[reflect.Type]func(interface{}) string `yaml:"-" json:"-"`
 }
 
 // Open initialize a configuration. An error is returned if the
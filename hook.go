@@ -0,0 +1,42 @@
+package dbi
+
+import (
+	"context"
+	"time"
+)
+
+// QueryHook lets callers observe the statements run by Query,
+// MultiQuery, Upsert, Transaction, Unmarshal, Insert, Update and the
+// Named methods (and their variants), e.g. for logging, metrics, or
+// OpenTelemetry span creation. QueryIter, UnmarshalIter, Migrate and
+// MigrateStatus do not call the hook. Before is
+// called right before a statement is executed and may return a
+// derived context, such as one carrying a started span, that is used
+// for the rest of the call; After is called once the statement has
+// finished, with the resulting error (nil on success) and how long it
+// took.
+type QueryHook interface {
+	Before(ctx context.Context, sql string, args []interface{}) context.Context
+	After(ctx context.Context, err error, duration time.Duration)
+}
+
+// SetQueryHook registers h to be invoked around each statement run by
+// the methods listed on QueryHook. A nil h disables hooking.
+func (c *Config) SetQueryHook(h QueryHook) {
+	c.hook = h
+}
+
+// hooked runs fn, invoking c.hook's Before/After around it when a
+// hook is registered.
+func (c *Config) hooked(ctx context.Context, sql string, args []interface{}, fn func(ctx context.Context) error) error {
+	if c.hook == nil {
+		return fn(ctx)
+	}
+
+	ctx = c.hook.Before(ctx, sql, args)
+	start := time.Now()
+	err := fn(ctx)
+	c.hook.After(ctx, err, time.Since(start))
+
+	return err
+}
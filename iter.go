@@ -0,0 +1,224 @@
+package dbi
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// RowIter iterates over the rows of a query without buffering the
+// whole result set in memory, for callers that need to stream large
+// exports. Callers must either exhaust it with Next until it returns
+// false, which closes it automatically, or call Close explicitly.
+type RowIter struct {
+	tx     *sql.Tx
+	rows   *sql.Rows
+	cols   []string
+	row    map[string]interface{}
+	err    error
+	closed bool
+}
+
+// QueryIter takes an SQL and data, and returns a RowIter over the
+// result rows instead of buffering them all in memory the way Query
+// does.
+func (c *Config) QueryIter(SQL string, args ...interface{}) (*RowIter, error) {
+	if c.Driver == "postgres" {
+		SQL = postgresPlaceholders(SQL)
+	}
+
+	tx, err := c.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(SQL, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &RowIter{tx: tx, rows: rows, cols: cols}, nil
+}
+
+// Next advances the iterator to the next row. It returns false once
+// there are no more rows or an error occurred, closing the iterator
+// in both cases; use Err to tell them apart.
+func (it *RowIter) Next() bool {
+	if it.closed {
+		return false
+	}
+
+	if !it.rows.Next() {
+		it.err = it.rows.Err()
+		it.Close()
+		return false
+	}
+
+	columns := make([]interface{}, len(it.cols))
+	columnPointers := make([]interface{}, len(it.cols))
+	for i := range columns {
+		columnPointers[i] = &columns[i]
+	}
+
+	if err := it.rows.Scan(columnPointers...); err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+
+	row := make(map[string]interface{})
+	for i, colName := range it.cols {
+		row[colName] = *(columnPointers[i].(*interface{}))
+	}
+	it.row = row
+
+	return true
+}
+
+// Row returns the current row as a string map to interface values.
+// It is only valid after a call to Next that returned true.
+func (it *RowIter) Row() map[string]interface{} {
+	return it.row
+}
+
+// Err returns the first error encountered while iterating, if any.
+func (it *RowIter) Err() error {
+	return it.err
+}
+
+// Close releases the underlying rows and commits the transaction the
+// iterator reads under. It is safe to call more than once.
+func (it *RowIter) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+
+	it.rows.Close()
+
+	return it.tx.Commit()
+}
+
+// StructIter iterates over the rows of a query, scanning each one
+// into a struct via its dbi tags, without buffering the whole result
+// set in memory.
+type StructIter struct {
+	tx       *sql.Tx
+	rows     *sql.Rows
+	cols     []string
+	populate map[string]taggroup
+	t        reflect.Type
+	err      error
+	closed   bool
+}
+
+// UnmarshalIter takes v, a pointer to a struct used only to describe
+// the row shape, and an SQL query, and returns a StructIter that
+// scans each result row into a struct of that type via Scan, instead
+// of buffering the whole result set into a slice the way Unmarshal
+// does. The mapping between row columns and struct fields uses the
+// same dbi tags as Unmarshal.
+func (c *Config) UnmarshalIter(v interface{}, SQL string, args ...interface{}) (*StructIter, error) {
+	vt := reflect.TypeOf(v)
+	if vt == nil || vt.Kind() != reflect.Ptr || vt.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("dbi: argument must be a pointer to a struct")
+	}
+	t := vt.Elem()
+
+	populate, _, err := populateTags(t)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Driver == "postgres" {
+		SQL = postgresPlaceholders(SQL)
+	}
+
+	tx, err := c.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(SQL, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		tx.Rollback()
+		return nil, err
+	}
+
+	colCheck := make(map[string]bool)
+	for _, col := range cols {
+		colCheck[col] = true
+	}
+	for column := range populate {
+		if _, ok := colCheck[column]; !ok {
+			rows.Close()
+			tx.Rollback()
+			return nil, fmt.Errorf("dbi: query didn't return any columns with name %s", column)
+		}
+	}
+
+	return &StructIter{tx: tx, rows: rows, cols: cols, populate: populate, t: t}, nil
+}
+
+// Next advances the iterator to the next row. It returns false once
+// there are no more rows or an error occurred, closing the iterator
+// in both cases; use Err to tell them apart.
+func (it *StructIter) Next() bool {
+	if it.closed {
+		return false
+	}
+
+	if !it.rows.Next() {
+		it.err = it.rows.Err()
+		it.Close()
+		return false
+	}
+
+	return true
+}
+
+// Scan copies the current row into dst, a pointer to a struct of the
+// same type passed to UnmarshalIter. It is only valid after a call to
+// Next that returned true, and must be called exactly once per row.
+// It honors the same dbi tag options as Unmarshal, including hex and
+// JSON/JSONB fields.
+func (it *StructIter) Scan(dst interface{}) error {
+	newe := reflect.ValueOf(dst).Elem()
+	t := newe.Type()
+
+	return scanRowInto(it.rows, it.cols, it.populate, newe, t)
+}
+
+// Err returns the first error encountered while iterating, if any.
+func (it *StructIter) Err() error {
+	return it.err
+}
+
+// Close releases the underlying rows and commits the transaction the
+// iterator reads under. It is safe to call more than once.
+func (it *StructIter) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+
+	it.rows.Close()
+
+	return it.tx.Commit()
+}
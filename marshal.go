@@ -0,0 +1,424 @@
+package dbi
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// populateTags parses the dbi struct tags of t, which must be a
+// struct type, the same way unmarshal does. It returns both a
+// column-indexed map, used to look up a field by a result column
+// name, and the same taggroups in field declaration order, used by
+// Insert/Update to emit columns in a stable order. An error is
+// returned if the same column name is tagged on more than one field.
+func populateTags(t reflect.Type) (map[string]taggroup, []taggroup, error) {
+	populate := make(map[string]taggroup)
+	var ordered []taggroup
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag == "" {
+			continue
+		}
+		if tag, ok := f.Tag.Lookup("dbi"); ok {
+			// Column and options are separted with commas. First "option" is the column name
+			options := strings.Split(tag, ",")
+			if len(options) > 0 && options[0] != "" {
+				tg := taggroup{Field: f.Name,
+					Column:  options[0],
+					Options: make(map[string]string)}
+				for _, option := range options[1:] {
+					tg.Options[option] = ""
+				}
+
+				if _, ok := populate[tg.Column]; ok {
+					return nil, nil, fmt.Errorf("dbi: column %s used on multiple fields", tg.Column)
+				}
+				populate[tg.Column] = tg
+				ordered = append(ordered, tg)
+			}
+		}
+	}
+
+	return populate, ordered, nil
+}
+
+// structRows verifies that v is a pointer to a struct, or a pointer
+// to a slice of pointers to a struct, and returns the addressable
+// struct values found along with their type.
+func structRows(v interface{}) ([]reflect.Value, reflect.Type, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return nil, nil, fmt.Errorf("dbi: argument must be a pointer to a struct or a pointer to a slice of pointers to a struct")
+	}
+
+	elem := rv.Elem()
+	switch elem.Kind() {
+	case reflect.Struct:
+		return []reflect.Value{elem}, elem.Type(), nil
+	case reflect.Slice:
+		et := elem.Type().Elem()
+		if et.Kind() != reflect.Ptr || et.Elem().Kind() != reflect.Struct {
+			return nil, nil, fmt.Errorf("dbi: argument must be a pointer to a struct or a pointer to a slice of pointers to a struct")
+		}
+		rows := make([]reflect.Value, elem.Len())
+		for i := range rows {
+			rows[i] = elem.Index(i).Elem()
+		}
+		return rows, et.Elem(), nil
+	default:
+		return nil, nil, fmt.Errorf("dbi: argument must be a pointer to a struct or a pointer to a slice of pointers to a struct")
+	}
+}
+
+// Insert marshals v, a pointer to a struct or a pointer to a slice of
+// pointers to a struct, into a parameterized INSERT statement per row
+// and executes all of them inside a single transaction against
+// table. Columns and behaviour are driven by the same dbi struct tags
+// used by Unmarshal: a field tagged auto is left out of the statement
+// and, on postgres, populated back from a RETURNING clause, and a
+// field tagged omitempty is left out of the statement whenever it
+// holds its zero value. On any other driver, a struct with a field
+// tagged auto is rejected with an error.
+func (c *Config) Insert(table string, v interface{}) error {
+	return c.InsertContext(context.Background(), table, v)
+}
+
+// InsertContext behaves like Insert, but carries ctx onto the
+// underlying driver calls, so callers can cancel a slow insert or
+// propagate a deadline.
+func (c *Config) InsertContext(ctx context.Context, table string, v interface{}) error {
+	return c.withRetry(func() error {
+		return c.insert(ctx, nil, table, v, false)
+	})
+}
+
+// InsertWithOptions behaves like Insert, but runs with the given
+// transaction options and, if c.RetryPolicy is set, retries the whole
+// operation from scratch when it fails on a serializable isolation
+// conflict or a detected deadlock.
+func (c *Config) InsertWithOptions(txOpts *sql.TxOptions, table string, v interface{}) error {
+	return c.withRetry(func() error {
+		return c.insert(context.Background(), txOpts, table, v, false)
+	})
+}
+
+// InsertOnConflict behaves like Insert, but appends a postgres "ON
+// CONFLICT (pk) DO UPDATE" clause built from the fields tagged pk, so
+// rows whose primary key already exists are updated instead of
+// rejected. It returns an error unless the driver is postgres.
+func (c *Config) InsertOnConflict(table string, v interface{}) error {
+	return c.InsertOnConflictContext(context.Background(), table, v)
+}
+
+// InsertOnConflictContext behaves like InsertOnConflict, but carries
+// ctx onto the underlying driver calls, so callers can cancel a slow
+// insert or propagate a deadline.
+func (c *Config) InsertOnConflictContext(ctx context.Context, table string, v interface{}) error {
+	if c.Driver != "postgres" {
+		return fmt.Errorf("dbi: InsertOnConflict requires the postgres driver")
+	}
+	return c.withRetry(func() error {
+		return c.insert(ctx, nil, table, v, true)
+	})
+}
+
+// InsertOnConflictWithOptions behaves like InsertOnConflict, but runs
+// with the given transaction options and, if c.RetryPolicy is set,
+// retries the whole operation from scratch when it fails on a
+// serializable isolation conflict or a detected deadlock.
+func (c *Config) InsertOnConflictWithOptions(txOpts *sql.TxOptions, table string, v interface{}) error {
+	if c.Driver != "postgres" {
+		return fmt.Errorf("dbi: InsertOnConflict requires the postgres driver")
+	}
+	return c.withRetry(func() error {
+		return c.insert(context.Background(), txOpts, table, v, true)
+	})
+}
+
+func (c *Config) insert(ctx context.Context, txOpts *sql.TxOptions, table string, v interface{}, onConflict bool) error {
+	rows, t, err := structRows(v)
+	if err != nil {
+		return err
+	}
+
+	_, ordered, err := populateTags(t)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			// a panic occurred, rollback and repanic
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			// something went wrong, rollback
+			tx.Rollback()
+		} else {
+			// all good, commit
+			err = tx.Commit()
+		}
+	}()
+
+	for i, row := range rows {
+		if err = c.insertRow(ctx, tx, table, ordered, row, onConflict); err != nil {
+			err = fmt.Errorf("dbi: insert row %d: %v", i, err)
+			return err
+		}
+	}
+
+	return err
+}
+
+// insertSQL builds the parameterized INSERT statement and argument
+// list for row, assembling columns, placeholders and, when onConflict
+// is set, the ON CONFLICT clause from ordered the same way insertRow
+// does. It touches no database, so the assembly logic can be unit
+// tested on its own. autoFields holds the tagged-auto fields that
+// insertRow must instead populate back via a RETURNING clause.
+func insertSQL(driver, table string, ordered []taggroup, row reflect.Value, onConflict bool) (string, []interface{}, []taggroup, error) {
+	var columns []string
+	var placeholders []string
+	var args []interface{}
+	var pkColumns []string
+	var autoFields []taggroup
+
+	for _, tg := range ordered {
+		field := row.FieldByName(tg.Field)
+		if _, ok := tg.Options["pk"]; ok {
+			pkColumns = append(pkColumns, tg.Column)
+		}
+		if _, ok := tg.Options["auto"]; ok {
+			autoFields = append(autoFields, tg)
+			continue
+		}
+		if _, ok := tg.Options["omitempty"]; ok && field.IsZero() {
+			continue
+		}
+
+		columns = append(columns, tg.Column)
+		args = append(args, field.Interface())
+		if driver == "postgres" {
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		} else {
+			placeholders = append(placeholders, "?")
+		}
+	}
+
+	if len(columns) == 0 {
+		return "", nil, nil, fmt.Errorf("dbi: nothing to insert")
+	}
+
+	SQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+
+	if onConflict {
+		if len(pkColumns) == 0 {
+			return "", nil, nil, fmt.Errorf("dbi: InsertOnConflict requires at least one field tagged pk")
+		}
+		var sets []string
+		for _, col := range columns {
+			if !stringInSlice(col, pkColumns) {
+				sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+			}
+		}
+		if len(sets) == 0 {
+			SQL += fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(pkColumns, ", "))
+		} else {
+			SQL += fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(pkColumns, ", "), strings.Join(sets, ", "))
+		}
+	}
+
+	if len(autoFields) > 0 && driver != "postgres" {
+		return "", nil, nil, fmt.Errorf("dbi: auto fields are only returned on the postgres driver")
+	}
+	if len(autoFields) > 0 {
+		var returning []string
+		for _, tg := range autoFields {
+			returning = append(returning, tg.Column)
+		}
+		SQL += fmt.Sprintf(" RETURNING %s", strings.Join(returning, ", "))
+	}
+
+	return SQL, args, autoFields, nil
+}
+
+func (c *Config) insertRow(ctx context.Context, tx *sql.Tx, table string, ordered []taggroup, row reflect.Value, onConflict bool) error {
+	SQL, args, autoFields, err := insertSQL(c.Driver, table, ordered, row, onConflict)
+	if err != nil {
+		return err
+	}
+
+	if len(autoFields) > 0 {
+		var scanTargets []interface{}
+		for _, tg := range autoFields {
+			scanTargets = append(scanTargets, row.FieldByName(tg.Field).Addr().Interface())
+		}
+
+		return c.hooked(ctx, SQL, args, func(ctx context.Context) error {
+			return tx.QueryRowContext(ctx, SQL, args...).Scan(scanTargets...)
+		})
+	}
+
+	return c.hooked(ctx, SQL, args, func(ctx context.Context) error {
+		_, execErr := tx.ExecContext(ctx, SQL, args...)
+		return execErr
+	})
+}
+
+// Update marshals v, a pointer to a struct or a pointer to a slice of
+// pointers to a struct, into a parameterized UPDATE statement per row
+// and executes all of them inside a single transaction against
+// table. The fields tagged pk are excluded from the SET clause and
+// used to build the WHERE clause instead, fields tagged auto are
+// skipped entirely, and a field tagged omitempty is left out of the
+// SET clause whenever it holds its zero value.
+func (c *Config) Update(table string, v interface{}) error {
+	return c.UpdateContext(context.Background(), table, v)
+}
+
+// UpdateContext behaves like Update, but carries ctx onto the
+// underlying driver calls, so callers can cancel a slow update or
+// propagate a deadline.
+func (c *Config) UpdateContext(ctx context.Context, table string, v interface{}) error {
+	return c.withRetry(func() error {
+		return c.update(ctx, nil, table, v)
+	})
+}
+
+// UpdateWithOptions behaves like Update, but runs with the given
+// transaction options and, if c.RetryPolicy is set, retries the whole
+// operation from scratch when it fails on a serializable isolation
+// conflict or a detected deadlock.
+func (c *Config) UpdateWithOptions(txOpts *sql.TxOptions, table string, v interface{}) error {
+	return c.withRetry(func() error {
+		return c.update(context.Background(), txOpts, table, v)
+	})
+}
+
+func (c *Config) update(ctx context.Context, txOpts *sql.TxOptions, table string, v interface{}) error {
+	rows, t, err := structRows(v)
+	if err != nil {
+		return err
+	}
+
+	_, ordered, err := populateTags(t)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			// a panic occurred, rollback and repanic
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			// something went wrong, rollback
+			tx.Rollback()
+		} else {
+			// all good, commit
+			err = tx.Commit()
+		}
+	}()
+
+	for i, row := range rows {
+		if err = c.updateRow(ctx, tx, table, ordered, row); err != nil {
+			err = fmt.Errorf("dbi: update row %d: %v", i, err)
+			return err
+		}
+	}
+
+	return err
+}
+
+// updateSQL builds the parameterized UPDATE statement and argument
+// list for row, assembling the SET and WHERE clauses from ordered the
+// same way updateRow does. It touches no database, so the assembly
+// logic can be unit tested on its own.
+func updateSQL(driver, table string, ordered []taggroup, row reflect.Value) (string, []interface{}, error) {
+	var setCols []string
+	var pkCols []string
+	var args []interface{}
+	var pkArgs []interface{}
+
+	for _, tg := range ordered {
+		field := row.FieldByName(tg.Field)
+		if _, ok := tg.Options["pk"]; ok {
+			pkCols = append(pkCols, tg.Column)
+			pkArgs = append(pkArgs, field.Interface())
+			continue
+		}
+		if _, ok := tg.Options["auto"]; ok {
+			continue
+		}
+		if _, ok := tg.Options["omitempty"]; ok && field.IsZero() {
+			continue
+		}
+
+		setCols = append(setCols, tg.Column)
+		args = append(args, field.Interface())
+	}
+
+	if len(pkCols) == 0 {
+		return "", nil, fmt.Errorf("dbi: Update requires at least one field tagged pk")
+	}
+	if len(setCols) == 0 {
+		return "", nil, fmt.Errorf("dbi: nothing to update")
+	}
+
+	var sets []string
+	for _, col := range setCols {
+		if driver == "postgres" {
+			sets = append(sets, fmt.Sprintf("%s = $%d", col, len(sets)+1))
+		} else {
+			sets = append(sets, fmt.Sprintf("%s = ?", col))
+		}
+	}
+
+	var where []string
+	for _, col := range pkCols {
+		if driver == "postgres" {
+			where = append(where, fmt.Sprintf("%s = $%d", col, len(setCols)+len(where)+1))
+		} else {
+			where = append(where, fmt.Sprintf("%s = ?", col))
+		}
+	}
+	args = append(args, pkArgs...)
+
+	SQL := fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(sets, ", "), strings.Join(where, " AND "))
+
+	return SQL, args, nil
+}
+
+func (c *Config) updateRow(ctx context.Context, tx *sql.Tx, table string, ordered []taggroup, row reflect.Value) error {
+	SQL, args, err := updateSQL(c.Driver, table, ordered, row)
+	if err != nil {
+		return err
+	}
+
+	return c.hooked(ctx, SQL, args, func(ctx context.Context) error {
+		_, execErr := tx.ExecContext(ctx, SQL, args...)
+		return execErr
+	})
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
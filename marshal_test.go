@@ -0,0 +1,175 @@
+package dbi
+
+import (
+	"reflect"
+	"testing"
+)
+
+type marshalRow struct {
+	ID     int    `dbi:"id,pk"`
+	Serial int    `dbi:"serial,auto"`
+	Name   string `dbi:"name"`
+	Note   string `dbi:"note,omitempty"`
+}
+
+type marshalCompositePKRow struct {
+	A int `dbi:"a,pk"`
+	B int `dbi:"b,pk"`
+}
+
+func orderedTags(t *testing.T, v interface{}) []taggroup {
+	t.Helper()
+	_, ordered, err := populateTags(reflect.TypeOf(v))
+	if err != nil {
+		t.Fatalf("populateTags: %v", err)
+	}
+	return ordered
+}
+
+func TestInsertSQL(t *testing.T) {
+	row := marshalRow{ID: 1, Serial: 99, Name: "x"}
+	ordered := orderedTags(t, row)
+
+	SQL, args, autoFields, err := insertSQL("postgres", "t", ordered, reflect.ValueOf(row), false)
+	if err != nil {
+		t.Fatalf("insertSQL: %v", err)
+	}
+
+	wantSQL := "INSERT INTO t (id, name) VALUES ($1, $2) RETURNING serial"
+	if SQL != wantSQL {
+		t.Fatalf("SQL = %q, want %q", SQL, wantSQL)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, "x"}) {
+		t.Fatalf("args = %v, want [1 x]", args)
+	}
+	if len(autoFields) != 1 || autoFields[0].Column != "serial" {
+		t.Fatalf("autoFields = %v, want [serial]", autoFields)
+	}
+}
+
+func TestInsertSQLOmitsEmptyOmitempty(t *testing.T) {
+	row := marshalRow{ID: 1, Serial: 99, Name: "x", Note: ""}
+	ordered := orderedTags(t, row)
+
+	SQL, _, _, err := insertSQL("postgres", "t", ordered, reflect.ValueOf(row), false)
+	if err != nil {
+		t.Fatalf("insertSQL: %v", err)
+	}
+	if SQL != "INSERT INTO t (id, name) VALUES ($1, $2) RETURNING serial" {
+		t.Fatalf("empty Note leaked into SQL: %q", SQL)
+	}
+}
+
+func TestInsertSQLNonPostgresRejectsAutoFields(t *testing.T) {
+	row := marshalRow{ID: 1, Name: "x"}
+	ordered := orderedTags(t, row)
+
+	SQL, _, autoFields, err := insertSQL("sqlite", "t", ordered, reflect.ValueOf(row), false)
+	if err == nil {
+		t.Fatalf("expected an error returning auto fields on a non-postgres driver")
+	}
+	if SQL != "" || autoFields != nil {
+		t.Fatalf("expected zero values on error, got SQL=%q autoFields=%v", SQL, autoFields)
+	}
+}
+
+func TestInsertSQLNothingToInsert(t *testing.T) {
+	row := marshalRow{ID: 1, Serial: 99}
+	ordered := []taggroup{orderedTags(t, row)[1]} // only the auto field
+
+	if _, _, _, err := insertSQL("postgres", "t", ordered, reflect.ValueOf(row), false); err == nil {
+		t.Fatalf("expected an error when every column is tagged auto")
+	}
+}
+
+func TestInsertSQLOnConflict(t *testing.T) {
+	row := marshalRow{ID: 1, Name: "x"}
+	ordered := orderedTags(t, row)
+
+	SQL, _, _, err := insertSQL("postgres", "t", ordered, reflect.ValueOf(row), true)
+	if err != nil {
+		t.Fatalf("insertSQL: %v", err)
+	}
+	want := "INSERT INTO t (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name RETURNING serial"
+	if SQL != want {
+		t.Fatalf("SQL = %q, want %q", SQL, want)
+	}
+}
+
+func TestInsertSQLOnConflictRequiresPK(t *testing.T) {
+	type noPK struct {
+		Name string `dbi:"name"`
+	}
+	row := noPK{Name: "x"}
+	ordered := orderedTags(t, row)
+
+	if _, _, _, err := insertSQL("postgres", "t", ordered, reflect.ValueOf(row), true); err == nil {
+		t.Fatalf("expected an error when no field is tagged pk")
+	}
+}
+
+func TestInsertSQLOnConflictAllPKFallsBackToDoNothing(t *testing.T) {
+	row := marshalCompositePKRow{A: 1, B: 2}
+	ordered := orderedTags(t, row)
+
+	SQL, _, _, err := insertSQL("postgres", "t", ordered, reflect.ValueOf(row), true)
+	if err != nil {
+		t.Fatalf("insertSQL: %v", err)
+	}
+	want := "INSERT INTO t (a, b) VALUES ($1, $2) ON CONFLICT (a, b) DO NOTHING"
+	if SQL != want {
+		t.Fatalf("SQL = %q, want %q", SQL, want)
+	}
+}
+
+func TestUpdateSQL(t *testing.T) {
+	row := marshalRow{ID: 1, Serial: 99, Name: "x"}
+	ordered := orderedTags(t, row)
+
+	SQL, args, err := updateSQL("postgres", "t", ordered, reflect.ValueOf(row))
+	if err != nil {
+		t.Fatalf("updateSQL: %v", err)
+	}
+
+	wantSQL := "UPDATE t SET name = $1 WHERE id = $2"
+	if SQL != wantSQL {
+		t.Fatalf("SQL = %q, want %q", SQL, wantSQL)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"x", 1}) {
+		t.Fatalf("args = %v, want [x 1]", args)
+	}
+}
+
+func TestUpdateSQLNonPostgresPlaceholders(t *testing.T) {
+	row := marshalRow{ID: 1, Name: "x"}
+	ordered := orderedTags(t, row)
+
+	SQL, _, err := updateSQL("sqlite", "t", ordered, reflect.ValueOf(row))
+	if err != nil {
+		t.Fatalf("updateSQL: %v", err)
+	}
+	if SQL != "UPDATE t SET name = ? WHERE id = ?" {
+		t.Fatalf("SQL = %q, want ? placeholders", SQL)
+	}
+}
+
+func TestUpdateSQLRequiresPK(t *testing.T) {
+	type noPK struct {
+		Name string `dbi:"name"`
+	}
+	row := noPK{Name: "x"}
+	ordered := orderedTags(t, row)
+
+	if _, _, err := updateSQL("postgres", "t", ordered, reflect.ValueOf(row)); err == nil {
+		t.Fatalf("expected an error when no field is tagged pk")
+	}
+}
+
+func TestUpdateSQLNothingToUpdate(t *testing.T) {
+	row := marshalRow{ID: 1}
+	ordered := []taggroup{orderedTags(t, row)[0]} // only the pk field
+
+	if _, _, err := updateSQL("postgres", "t", ordered, reflect.ValueOf(row)); err == nil {
+		t.Fatalf("expected an error when there is nothing left to set")
+	}
+}
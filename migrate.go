@@ -0,0 +1,338 @@
+package dbi
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/stianwa/dbi/migrate"
+)
+
+// migrationsTable is the bookkeeping table Migrate and MigrateStatus
+// track applied versions in.
+const migrationsTable = "schema_migrations"
+
+// migrationLockKey is the key Migrate takes a postgres advisory lock
+// on for the duration of a run, so concurrent processes migrating the
+// same database don't race.
+const migrationLockKey = 7224153131
+
+// MigrateOption configures a Migrate call. With no options, Migrate
+// applies every pending migration (Up).
+type MigrateOption func(*migrateOptions)
+
+type migrateOptions struct {
+	down      bool
+	force     bool
+	hasTarget bool
+	target    int64
+}
+
+// UpTo limits Migrate to applying pending migrations through and
+// including version, instead of every pending migration.
+func UpTo(version int64) MigrateOption {
+	return func(o *migrateOptions) {
+		o.hasTarget = true
+		o.target = version
+	}
+}
+
+// Down reverses the single most recently applied migration.
+func Down() MigrateOption {
+	return func(o *migrateOptions) {
+		o.down = true
+	}
+}
+
+// DownTo reverses applied migrations, newest first, down to and
+// including version.
+func DownTo(version int64) MigrateOption {
+	return func(o *migrateOptions) {
+		o.down = true
+		o.hasTarget = true
+		o.target = version
+	}
+}
+
+// Force marks version as applied, recording its current checksum
+// without running its migration. It is an escape hatch for repairing
+// a schema_migrations table left out of sync with the schema, e.g.
+// after a migration was edited and re-applied by hand.
+func Force(version int64) MigrateOption {
+	return func(o *migrateOptions) {
+		o.force = true
+		o.hasTarget = true
+		o.target = version
+	}
+}
+
+// MigrationStatus reports whether a discovered migration has been
+// applied, as returned by Config.MigrateStatus.
+type MigrationStatus struct {
+	Version     int64
+	Description string
+	Applied     bool
+	AppliedAt   *time.Time
+	Checksum    string
+}
+
+// Migrate discovers migrations from fsys, the .sql files named
+// NNNN_description.up.sql / NNNN_description.down.sql (or Go funcs
+// registered with migrate.Register), and applies every pending one in
+// version order inside its own transaction. With no options it runs
+// every pending migration (Up); UpTo, Down, DownTo and Force change
+// that, see their documentation.
+//
+// A postgres advisory lock is held for the duration of the run so
+// concurrent processes don't race, and the checksum of every
+// already-applied file is verified against what was recorded when it
+// ran, to catch edits made to a migration after the fact. Migrate is
+// only supported for the postgres driver.
+func (c *Config) Migrate(fsys fs.FS, opts ...MigrateOption) error {
+	if c.Driver != "postgres" {
+		return fmt.Errorf("dbi: migrate is only supported for the postgres driver")
+	}
+
+	var o migrateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	migrations, err := migrate.Discover(fsys)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	lockConn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer lockConn.Close()
+
+	if _, err := lockConn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("dbi: migrate: advisory lock: %v", err)
+	}
+	defer lockConn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	if err := c.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+
+	applied, err := c.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !o.force {
+		if err := verifyChecksums(migrations, applied); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case o.force:
+		return c.forceVersion(ctx, migrations, o.target)
+	case o.down:
+		return c.runDown(ctx, migrations, applied, o)
+	default:
+		return c.runUp(ctx, migrations, applied, o)
+	}
+}
+
+// MigrateStatus discovers migrations from fsys the same way Migrate
+// does, and reports, for each one in version order, whether it has
+// been applied and when.
+func (c *Config) MigrateStatus(fsys fs.FS) ([]MigrationStatus, error) {
+	if c.Driver != "postgres" {
+		return nil, fmt.Errorf("dbi: migrate is only supported for the postgres driver")
+	}
+
+	migrations, err := migrate.Discover(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	if err := c.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+
+	applied, err := c.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		st := applied[m.Version]
+		st.Version = m.Version
+		st.Description = m.Description
+		if !st.Applied {
+			st.Checksum = m.Checksum
+		}
+		statuses[i] = st
+	}
+
+	return statuses, nil
+}
+
+func (c *Config) ensureMigrationsTable(ctx context.Context) error {
+	SQL := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		version bigint PRIMARY KEY,
+		applied_at timestamptz NOT NULL,
+		checksum text NOT NULL
+	)`, migrationsTable)
+
+	_, err := c.db.ExecContext(ctx, SQL)
+	return err
+}
+
+func (c *Config) appliedMigrations(ctx context.Context) (map[int64]MigrationStatus, error) {
+	rows, err := c.db.QueryContext(ctx, fmt.Sprintf("SELECT version, applied_at, checksum FROM %s", migrationsTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]MigrationStatus)
+	for rows.Next() {
+		var st MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&st.Version, &appliedAt, &st.Checksum); err != nil {
+			return nil, err
+		}
+		st.Applied = true
+		st.AppliedAt = &appliedAt
+		applied[st.Version] = st
+	}
+
+	return applied, rows.Err()
+}
+
+func verifyChecksums(migrations []migrate.Migration, applied map[int64]MigrationStatus) error {
+	for _, m := range migrations {
+		st, ok := applied[m.Version]
+		if !ok || m.Checksum == "" {
+			continue
+		}
+		if st.Checksum != m.Checksum {
+			return fmt.Errorf("dbi: migrate: version %d has been modified since it was applied (checksum mismatch)", m.Version)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) runUp(ctx context.Context, migrations []migrate.Migration, applied map[int64]MigrationStatus, o migrateOptions) error {
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if o.hasTarget && m.Version > o.target {
+			break
+		}
+
+		if err := c.runMigration(ctx, m, true); err != nil {
+			return fmt.Errorf("dbi: migrate: applying version %d: %v", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) runDown(ctx context.Context, migrations []migrate.Migration, applied map[int64]MigrationStatus, o migrateOptions) error {
+	sorted := make([]migrate.Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version > sorted[j].Version })
+
+	for _, m := range sorted {
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if o.hasTarget && m.Version < o.target {
+			break
+		}
+
+		if err := c.runMigration(ctx, m, false); err != nil {
+			return fmt.Errorf("dbi: migrate: reversing version %d: %v", m.Version, err)
+		}
+
+		if !o.hasTarget {
+			// Down with no target reverses only the single most
+			// recently applied migration.
+			break
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) runMigration(ctx context.Context, m migrate.Migration, up bool) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if up {
+		if m.UpFunc != nil {
+			err = m.UpFunc(tx)
+		} else {
+			_, err = tx.ExecContext(ctx, m.Up)
+		}
+	} else {
+		if m.DownFunc != nil {
+			err = m.DownFunc(tx)
+		} else {
+			_, err = tx.ExecContext(ctx, m.Down)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (version, applied_at, checksum) VALUES ($1, now(), $2)", migrationsTable), m.Version, m.Checksum)
+	} else {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE version = $1", migrationsTable), m.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c *Config) forceVersion(ctx context.Context, migrations []migrate.Migration, version int64) error {
+	var checksum string
+	var found bool
+	for _, m := range migrations {
+		if m.Version == version {
+			checksum = m.Checksum
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("dbi: force: no migration with version %d", version)
+	}
+
+	SQL := fmt.Sprintf(`INSERT INTO %s (version, applied_at, checksum) VALUES ($1, now(), $2)
+		ON CONFLICT (version) DO UPDATE SET applied_at = EXCLUDED.applied_at, checksum = EXCLUDED.checksum`, migrationsTable)
+
+	_, err := c.db.ExecContext(ctx, SQL, version, checksum)
+	return err
+}
@@ -0,0 +1,145 @@
+// Package migrate discovers and describes schema migrations driven
+// by a Config's Migrate and MigrateStatus methods. A migration is
+// either a pair of SQL files named NNNN_description.up.sql and
+// NNNN_description.down.sql discovered from an fs.FS (so callers can
+// embed.FS them into their binary), or a pair of Go functions added
+// with Register.
+package migrate
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Migration describes a single schema migration, either file-based
+// (Up/Down hold the SQL to run) or Go-func based (UpFunc/DownFunc do).
+type Migration struct {
+	// Version uniquely identifies the migration and determines the
+	// order migrations are applied in.
+	Version int64
+	// Description is the human readable part of the migration's
+	// file name, or the description passed to Register.
+	Description string
+	// Up and Down hold the SQL to run, for a migration discovered
+	// from .sql files.
+	Up, Down string
+	// UpFunc and DownFunc hold the Go functions to run, for a
+	// migration added with Register.
+	UpFunc, DownFunc func(*sql.Tx) error
+	// Checksum is the sha256 hex digest of Up, used by Config.Migrate
+	// to detect edits to an already-applied migration file. It is
+	// empty for Go-func migrations.
+	Checksum string
+}
+
+var registered = make(map[int64]Migration)
+
+// Register adds a Go-func migration to the registry that Discover
+// merges in alongside any file-based migrations. It panics if version
+// has already been registered, since that is a programming error
+// that should be caught at init time.
+func Register(version int64, description string, up, down func(*sql.Tx) error) {
+	if _, ok := registered[version]; ok {
+		panic(fmt.Sprintf("migrate: version %d registered more than once", version))
+	}
+
+	registered[version] = Migration{
+		Version:     version,
+		Description: description,
+		UpFunc:      up,
+		DownFunc:    down,
+	}
+}
+
+var fileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Discover reads NNNN_description.up.sql / NNNN_description.down.sql
+// pairs from fsys and merges them with every migration added via
+// Register, returning the combined set sorted by version. An error is
+// returned if a version is missing its up or down file, is defined
+// both as files and via Register, or is defined more than once.
+func Discover(fsys fs.FS) ([]Migration, error) {
+	byVersion := make(map[int64]*Migration)
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("migrate: reading migrations: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		m := fileRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s: %v", entry.Name(), err)
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("migrate: reading %s: %v", entry.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Description: m[2]}
+			byVersion[version] = mig
+		} else if mig.Description != m[2] {
+			return nil, fmt.Errorf("migrate: version %d defined more than once", version)
+		}
+
+		switch m[3] {
+		case "up":
+			if mig.Up != "" {
+				return nil, fmt.Errorf("migrate: version %d defined more than once", version)
+			}
+			mig.Up = string(data)
+			sum := sha256.Sum256(data)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		case "down":
+			if mig.Down != "" {
+				return nil, fmt.Errorf("migrate: version %d defined more than once", version)
+			}
+			mig.Down = string(data)
+		}
+	}
+
+	var out []Migration
+	for version, mig := range byVersion {
+		if _, ok := registered[version]; ok {
+			return nil, fmt.Errorf("migrate: version %d defined both as files and via Register", version)
+		}
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migrate: version %d is missing its .up.sql file", version)
+		}
+		if mig.Down == "" {
+			return nil, fmt.Errorf("migrate: version %d is missing its .down.sql file", version)
+		}
+		out = append(out, *mig)
+	}
+	for _, mig := range registered {
+		out = append(out, mig)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+
+	for i := 1; i < len(out); i++ {
+		if out[i].Version == out[i-1].Version {
+			return nil, fmt.Errorf("migrate: version %d defined more than once", out[i].Version)
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,105 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDiscoverFilePairs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_init.up.sql":   {Data: []byte("CREATE TABLE t (id int);")},
+		"0001_init.down.sql": {Data: []byte("DROP TABLE t;")},
+		"0002_add.up.sql":    {Data: []byte("ALTER TABLE t ADD COLUMN n int;")},
+		"0002_add.down.sql":  {Data: []byte("ALTER TABLE t DROP COLUMN n;")},
+		"README.md":          {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Discover(fsys)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("len(migrations) = %d, want 2", len(migrations))
+	}
+
+	if migrations[0].Version != 1 || migrations[1].Version != 2 {
+		t.Fatalf("versions = %d, %d, want 1, 2", migrations[0].Version, migrations[1].Version)
+	}
+	if migrations[0].Description != "init" {
+		t.Fatalf("Description = %q, want %q", migrations[0].Description, "init")
+	}
+
+	sum := sha256.Sum256([]byte("CREATE TABLE t (id int);"))
+	wantChecksum := hex.EncodeToString(sum[:])
+	if migrations[0].Checksum != wantChecksum {
+		t.Fatalf("Checksum = %q, want %q", migrations[0].Checksum, wantChecksum)
+	}
+}
+
+func TestDiscoverMissingDownFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_init.up.sql": {Data: []byte("CREATE TABLE t (id int);")},
+	}
+
+	if _, err := Discover(fsys); err == nil {
+		t.Fatalf("expected an error for a missing .down.sql file")
+	}
+}
+
+func TestDiscoverMissingUpFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_init.down.sql": {Data: []byte("DROP TABLE t;")},
+	}
+
+	if _, err := Discover(fsys); err == nil {
+		t.Fatalf("expected an error for a missing .up.sql file")
+	}
+}
+
+func TestDiscoverMergesRegistered(t *testing.T) {
+	Register(90001, "go func migration", func(*sql.Tx) error { return nil }, func(*sql.Tx) error { return nil })
+
+	fsys := fstest.MapFS{
+		"0001_init.up.sql":   {Data: []byte("CREATE TABLE t (id int);")},
+		"0001_init.down.sql": {Data: []byte("DROP TABLE t;")},
+	}
+
+	migrations, err := Discover(fsys)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("len(migrations) = %d, want 2", len(migrations))
+	}
+	if migrations[1].Version != 90001 || migrations[1].UpFunc == nil {
+		t.Fatalf("registered migration missing from Discover result: %+v", migrations[1])
+	}
+}
+
+func TestDiscoverVersionDefinedBothAsFilesAndRegistered(t *testing.T) {
+	Register(90002, "go func migration", func(*sql.Tx) error { return nil }, func(*sql.Tx) error { return nil })
+
+	fsys := fstest.MapFS{
+		"0090002_init.up.sql":   {Data: []byte("CREATE TABLE t (id int);")},
+		"0090002_init.down.sql": {Data: []byte("DROP TABLE t;")},
+	}
+
+	if _, err := Discover(fsys); err == nil {
+		t.Fatalf("expected an error for a version defined both as files and via Register")
+	}
+}
+
+func TestRegisterPanicsOnDuplicateVersion(t *testing.T) {
+	Register(90003, "first", func(*sql.Tx) error { return nil }, func(*sql.Tx) error { return nil })
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic registering version 90003 twice")
+		}
+	}()
+
+	Register(90003, "second", func(*sql.Tx) error { return nil }, func(*sql.Tx) error { return nil })
+}
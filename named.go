@@ -0,0 +1,346 @@
+package dbi
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// UnboundParameterError is returned by NamedQuery, NamedExec and
+// NamedUnmarshal when SQL references a :name parameter that has no
+// matching key in the binding map or struct.
+type UnboundParameterError struct {
+	Name string
+}
+
+// Error implements the error interface.
+func (e *UnboundParameterError) Error() string {
+	return fmt.Sprintf("dbi: no binding for named parameter :%s", e.Name)
+}
+
+// NamedQuery takes an SQL statement using :name parameters and a
+// binding, either a map[string]interface{} or a pointer to a struct
+// whose dbi tags supply the parameter names, and returns rows with
+// string map to interfaces values, the same way Query does. A bound
+// value that is a slice is expanded into the matching number of
+// placeholders, so :ids can be used inside IN (:ids).
+func (c *Config) NamedQuery(SQL string, binding interface{}) ([]map[string]interface{}, error) {
+	return c.NamedQueryContext(context.Background(), SQL, binding)
+}
+
+// NamedQueryContext behaves like NamedQuery, but carries ctx onto the
+// underlying driver calls, so callers can cancel a slow query or
+// propagate a deadline.
+func (c *Config) NamedQueryContext(ctx context.Context, SQL string, binding interface{}) ([]map[string]interface{}, error) {
+	rewritten, args, err := c.rewriteNamedSQL(SQL, binding)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.QueryContext(ctx, rewritten, args...)
+}
+
+// NamedExec takes an SQL statement using :name parameters and a
+// binding, either a map[string]interface{} or a pointer to a struct
+// whose dbi tags supply the parameter names, and executes it inside
+// its own transaction.
+func (c *Config) NamedExec(SQL string, binding interface{}) error {
+	return c.NamedExecContext(context.Background(), SQL, binding)
+}
+
+// NamedExecContext behaves like NamedExec, but carries ctx onto the
+// underlying driver calls, so callers can cancel a slow exec or
+// propagate a deadline.
+func (c *Config) NamedExecContext(ctx context.Context, SQL string, binding interface{}) error {
+	rewritten, args, err := c.rewriteNamedSQL(SQL, binding)
+	if err != nil {
+		return err
+	}
+
+	return c.withRetry(func() error {
+		return c.namedExec(ctx, nil, rewritten, args)
+	})
+}
+
+// NamedExecWithOptions behaves like NamedExec, but runs with the
+// given transaction options and, if c.RetryPolicy is set, retries the
+// whole operation from scratch when it fails on a serializable
+// isolation conflict or a detected deadlock.
+func (c *Config) NamedExecWithOptions(txOpts *sql.TxOptions, SQL string, binding interface{}) error {
+	rewritten, args, err := c.rewriteNamedSQL(SQL, binding)
+	if err != nil {
+		return err
+	}
+
+	return c.withRetry(func() error {
+		return c.namedExec(context.Background(), txOpts, rewritten, args)
+	})
+}
+
+func (c *Config) namedExec(ctx context.Context, txOpts *sql.TxOptions, SQL string, args []interface{}) error {
+	if c.Driver == "postgres" {
+		SQL = postgresPlaceholders(SQL)
+	}
+
+	tx, err := c.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			// a panic occurred, rollback and repanic
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			// something went wrong, rollback
+			tx.Rollback()
+		} else {
+			// all good, commit
+			err = tx.Commit()
+		}
+	}()
+
+	err = c.hooked(ctx, SQL, args, func(ctx context.Context) error {
+		_, execErr := tx.ExecContext(ctx, SQL, args...)
+		return execErr
+	})
+
+	return err
+}
+
+// NamedUnmarshal rows into a slice with pointers to a struct, the
+// same way Unmarshal does, but SQL uses :name parameters bound from
+// binding, either a map[string]interface{} or a pointer to a struct
+// whose dbi tags supply the parameter names.
+func (c *Config) NamedUnmarshal(v interface{}, SQL string, binding interface{}) error {
+	return c.NamedUnmarshalContext(context.Background(), v, SQL, binding)
+}
+
+// NamedUnmarshalContext behaves like NamedUnmarshal, but carries ctx
+// onto the underlying driver calls, so callers can cancel a slow
+// query or propagate a deadline.
+func (c *Config) NamedUnmarshalContext(ctx context.Context, v interface{}, SQL string, binding interface{}) error {
+	rewritten, args, err := c.rewriteNamedSQL(SQL, binding)
+	if err != nil {
+		return err
+	}
+
+	return c.UnmarshalContext(ctx, v, rewritten, args...)
+}
+
+func (c *Config) rewriteNamedSQL(SQL string, binding interface{}) (string, []interface{}, error) {
+	m, err := namedBindingMap(binding)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return rewriteNamed(SQL, m)
+}
+
+// namedBindingMap turns binding, either a map[string]interface{} or a
+// pointer to a struct whose fields carry dbi tags, into a plain
+// map[string]interface{} keyed by parameter name.
+func namedBindingMap(binding interface{}) (map[string]interface{}, error) {
+	if m, ok := binding.(map[string]interface{}); ok {
+		return m, nil
+	}
+
+	rv := reflect.ValueOf(binding)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("dbi: binding must be a map[string]interface{} or a pointer to a struct")
+	}
+
+	_, ordered, err := populateTags(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{})
+	for _, tg := range ordered {
+		m[tg.Column] = rv.FieldByName(tg.Field).Interface()
+	}
+
+	return m, nil
+}
+
+// rewriteNamed scans SQL for :name parameters and rewrites it to use
+// '?' placeholders, returning the argument slice in the matching
+// order. It skips content inside '...', "...", E'...' string
+// literals, $tag$...$tag$ dollar-quoted strings, and --/* */ comments
+// so a ':' found there isn't mistaken for a parameter, and it leaves
+// postgres '::' type casts untouched. A bound value that is a slice
+// (other than []byte) is expanded into one placeholder per element
+// and its elements are flattened into args.
+func rewriteNamed(SQL string, binding map[string]interface{}) (string, []interface{}, error) {
+	runes := []rune(SQL)
+	n := len(runes)
+
+	var out strings.Builder
+	var args []interface{}
+
+	for i := 0; i < n; {
+		ch := runes[i]
+
+		switch {
+		case ch == '\'' || ch == '"':
+			j := skipQuoted(runes, i+1, ch)
+			out.WriteString(string(runes[i:j]))
+			i = j
+		case ch == 'E' && i+1 < n && runes[i+1] == '\'':
+			j := skipEscapedQuoted(runes, i+2, '\'')
+			out.WriteString(string(runes[i:j]))
+			i = j
+		case ch == '$':
+			if end, ok := skipDollarQuoted(runes, i); ok {
+				out.WriteString(string(runes[i:end]))
+				i = end
+			} else {
+				out.WriteRune(ch)
+				i++
+			}
+		case ch == '-' && i+1 < n && runes[i+1] == '-':
+			j := i
+			for j < n && runes[j] != '\n' {
+				j++
+			}
+			out.WriteString(string(runes[i:j]))
+			i = j
+		case ch == '/' && i+1 < n && runes[i+1] == '*':
+			j := i + 2
+			for j+1 < n && !(runes[j] == '*' && runes[j+1] == '/') {
+				j++
+			}
+			j = min(j+2, n)
+			out.WriteString(string(runes[i:j]))
+			i = j
+		case ch == ':' && i+1 < n && runes[i+1] == ':':
+			out.WriteString("::")
+			i += 2
+		case ch == ':' && i+1 < n && isNameRune(runes[i+1]):
+			j := i + 1
+			for j < n && isNameRune(runes[j]) {
+				j++
+			}
+			name := string(runes[i+1 : j])
+
+			value, ok := binding[name]
+			if !ok {
+				return "", nil, &UnboundParameterError{Name: name}
+			}
+
+			placeholder, values, err := expandNamedValue(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("dbi: named parameter :%s: %v", name, err)
+			}
+			out.WriteString(placeholder)
+			args = append(args, values...)
+			i = j
+		default:
+			out.WriteRune(ch)
+			i++
+		}
+	}
+
+	return out.String(), args, nil
+}
+
+// expandNamedValue renders a single '?' placeholder for a scalar
+// value, or, when value is a slice other than []byte, one '?' per
+// element separated by commas so it can be used inside IN (:name). It
+// returns an error for an empty slice, since rendering it would
+// produce IN () with nothing between the parens, which postgres
+// rejects as a syntax error.
+func expandNamedValue(value interface{}) (string, []interface{}, error) {
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() || rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return "?", []interface{}{value}, nil
+	}
+
+	if rv.Len() == 0 {
+		return "", nil, fmt.Errorf("bound to an empty slice")
+	}
+
+	placeholders := make([]string, rv.Len())
+	values := make([]interface{}, rv.Len())
+	for i := range placeholders {
+		placeholders[i] = "?"
+		values[i] = rv.Index(i).Interface()
+	}
+
+	return strings.Join(placeholders, ", "), values, nil
+}
+
+// skipQuoted scans a standard-conforming '...' or "..." literal,
+// where the only escape is the quote character doubled (e.g. 'it''s');
+// backslash has no special meaning here, unlike in an E'...' literal.
+func skipQuoted(runes []rune, i int, quote rune) int {
+	n := len(runes)
+	for i < n {
+		if runes[i] == quote {
+			return i + 1
+		}
+		i++
+	}
+
+	return n
+}
+
+// skipEscapedQuoted scans an E'...' literal, where a backslash
+// escapes the following rune, including the closing quote.
+func skipEscapedQuoted(runes []rune, i int, quote rune) int {
+	n := len(runes)
+	for i < n {
+		if runes[i] == '\\' && i+1 < n {
+			i += 2
+			continue
+		}
+		if runes[i] == quote {
+			return i + 1
+		}
+		i++
+	}
+
+	return n
+}
+
+// skipDollarQuoted detects a postgres dollar-quoted string starting
+// at runes[i] == '$' and returns the index just past its closing tag.
+func skipDollarQuoted(runes []rune, i int) (int, bool) {
+	n := len(runes)
+	j := i + 1
+	for j < n && isNameRune(runes[j]) {
+		j++
+	}
+	if j >= n || runes[j] != '$' {
+		return 0, false
+	}
+
+	tag := string(runes[i : j+1])
+	end := j + 1
+	for end < n {
+		if end+len(tag) <= n && string(runes[end:end+len(tag)]) == tag {
+			return end + len(tag), true
+		}
+		end++
+	}
+
+	return 0, false
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
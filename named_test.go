@@ -0,0 +1,146 @@
+package dbi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRewriteNamed(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		binding  map[string]interface{}
+		wantSQL  string
+		wantArgs []interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "simple substitution",
+			sql:      "SELECT * FROM t WHERE id = :id",
+			binding:  map[string]interface{}{"id": 1},
+			wantSQL:  "SELECT * FROM t WHERE id = ?",
+			wantArgs: []interface{}{1},
+		},
+		{
+			name:     "repeated name reuses binding",
+			sql:      "SELECT * FROM t WHERE a = :x OR b = :x",
+			binding:  map[string]interface{}{"x": "v"},
+			wantSQL:  "SELECT * FROM t WHERE a = ? OR b = ?",
+			wantArgs: []interface{}{"v", "v"},
+		},
+		{
+			name:    "unbound parameter",
+			sql:     "SELECT * FROM t WHERE id = :missing",
+			binding: map[string]interface{}{},
+			wantErr: true,
+		},
+		{
+			name:     "double colon cast untouched",
+			sql:      "SELECT :id::text",
+			binding:  map[string]interface{}{"id": 1},
+			wantSQL:  "SELECT ?::text",
+			wantArgs: []interface{}{1},
+		},
+		{
+			name:     "slice expands into placeholders",
+			sql:      "SELECT * FROM t WHERE id IN (:ids)",
+			binding:  map[string]interface{}{"ids": []int{1, 2, 3}},
+			wantSQL:  "SELECT * FROM t WHERE id IN (?, ?, ?)",
+			wantArgs: []interface{}{1, 2, 3},
+		},
+		{
+			name:     "colon inside single-quoted string is not a parameter",
+			sql:      "SELECT * FROM t WHERE note = 'time:now' AND id = :id",
+			binding:  map[string]interface{}{"id": 1},
+			wantSQL:  "SELECT * FROM t WHERE note = 'time:now' AND id = ?",
+			wantArgs: []interface{}{1},
+		},
+		{
+			name:     "doubled quote inside string is not a closing quote",
+			sql:      "SELECT * FROM t WHERE note = 'it''s :not a param' AND id = :id",
+			binding:  map[string]interface{}{"id": 1},
+			wantSQL:  "SELECT * FROM t WHERE note = 'it''s :not a param' AND id = ?",
+			wantArgs: []interface{}{1},
+		},
+		{
+			// A trailing backslash in a plain (non-E) literal is not an
+			// escape under standard_conforming_strings, so the quote
+			// right after it closes the string and :id is still found.
+			name:     "trailing backslash in plain string does not escape the closing quote",
+			sql:      "SELECT * FROM t WHERE path = 'C:\\' AND id = :id",
+			binding:  map[string]interface{}{"id": 1},
+			wantSQL:  "SELECT * FROM t WHERE path = 'C:\\' AND id = ?",
+			wantArgs: []interface{}{1},
+		},
+		{
+			// In an E'...' literal, backslash does escape the
+			// following rune, including a quote, so the whole rest of
+			// the statement is swallowed as string content and :id is
+			// never recognized as a parameter.
+			name:     "trailing backslash in E-string escapes the closing quote",
+			sql:      "SELECT * FROM t WHERE path = E'C:\\' AND id = :id'",
+			binding:  map[string]interface{}{"id": 1},
+			wantSQL:  "SELECT * FROM t WHERE path = E'C:\\' AND id = :id'",
+			wantArgs: nil,
+		},
+		{
+			name:    "empty slice is an error, not IN ()",
+			sql:     "SELECT * FROM t WHERE id IN (:ids)",
+			binding: map[string]interface{}{"ids": []int{}},
+			wantErr: true,
+		},
+		{
+			name:     "dollar quoted string untouched",
+			sql:      "SELECT $$literal :not a param$$ WHERE id = :id",
+			binding:  map[string]interface{}{"id": 1},
+			wantSQL:  "SELECT $$literal :not a param$$ WHERE id = ?",
+			wantArgs: []interface{}{1},
+		},
+		{
+			name:     "line comment untouched",
+			sql:      "SELECT * FROM t -- :not a param\nWHERE id = :id",
+			binding:  map[string]interface{}{"id": 1},
+			wantSQL:  "SELECT * FROM t -- :not a param\nWHERE id = ?",
+			wantArgs: []interface{}{1},
+		},
+		{
+			name:     "block comment untouched",
+			sql:      "SELECT * FROM t /* :not a param */ WHERE id = :id",
+			binding:  map[string]interface{}{"id": 1},
+			wantSQL:  "SELECT * FROM t /* :not a param */ WHERE id = ?",
+			wantArgs: []interface{}{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSQL, gotArgs, err := rewriteNamed(tt.sql, tt.binding)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotSQL != tt.wantSQL {
+				t.Fatalf("sql = %q, want %q", gotSQL, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Fatalf("args = %v, want %v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestRewriteNamedUnboundParameterError(t *testing.T) {
+	_, _, err := rewriteNamed("SELECT * FROM t WHERE id = :id", map[string]interface{}{})
+	unbound, ok := err.(*UnboundParameterError)
+	if !ok {
+		t.Fatalf("expected *UnboundParameterError, got %T: %v", err, err)
+	}
+	if unbound.Name != "id" {
+		t.Fatalf("Name = %q, want %q", unbound.Name, "id")
+	}
+}
@@ -1,9 +1,9 @@
 package dbi
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"time"
 )
 
 // QueryInterface takes an SQL and data, and returns rows with string
@@ -38,32 +38,7 @@ func (c *Config) QueryString(sql string, args ...interface{}) ([]map[string]stri
 	for _, row := range rows[0] {
 		l := make(map[string]string)
 		for k, v := range row {
-			str := ""
-			if v != nil {
-				switch t := v.(type) {
-				case string:
-					str = v.(string)
-				case int64:
-					str = fmt.Sprintf("%d", v)
-				case int32:
-					str = fmt.Sprintf("%d", v)
-				case int16:
-					str = fmt.Sprintf("%d", v)
-				case uint8:
-					str = fmt.Sprintf("%c", v)
-				case float32:
-					str = fmt.Sprintf("%f", v)
-				case float64:
-					str = fmt.Sprintf("%f", v)
-				case bool:
-					str = fmt.Sprintf("%t", v)
-				case time.Time:
-					str = fmt.Sprintf("%s", t.Format(time.RFC3339Nano))
-				default:
-					str = fmt.Sprintf("%s", v)
-				}
-			}
-			l[k] = str
+			l[k] = c.stringify(v)
 		}
 		n = append(n, l)
 	}
@@ -74,7 +49,14 @@ func (c *Config) QueryString(sql string, args ...interface{}) ([]map[string]stri
 // Query takes an SQL and data, and returns rows with string map to
 // interfaces values.
 func (c *Config) Query(sql string, args ...interface{}) ([]map[string]interface{}, error) {
-	rows, err := c.MultiQuery([]string{sql}, args)
+	return c.QueryContext(context.Background(), sql, args...)
+}
+
+// QueryContext behaves like Query, but carries ctx onto the
+// underlying driver calls, so callers can cancel a slow query or
+// propagate a deadline.
+func (c *Config) QueryContext(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := c.MultiQueryContext(ctx, []string{sql}, args)
 	if err != nil {
 		return nil, err
 	}
@@ -85,14 +67,55 @@ func (c *Config) Query(sql string, args ...interface{}) ([]map[string]interface{
 // MultiQuery takes a SQL list and data list (paired), and returns set
 // of rows with string map to interfaces values.
 func (c *Config) MultiQuery(sql []string, args ...[]interface{}) ([][]map[string]interface{}, error) {
+	return c.MultiQueryContext(context.Background(), sql, args...)
+}
+
+// MultiQueryContext behaves like MultiQuery, but carries ctx onto the
+// underlying driver calls, so callers can cancel a slow query or
+// propagate a deadline.
+func (c *Config) MultiQueryContext(ctx context.Context, sql []string, args ...[]interface{}) ([][]map[string]interface{}, error) {
+	var ret [][]map[string]interface{}
+
+	err := c.withRetry(func() error {
+		rows, err := c.multiQuery(ctx, nil, sql, args...)
+		if err != nil {
+			return err
+		}
+		ret = rows
+		return nil
+	})
+
+	return ret, err
+}
+
+// MultiQueryWithOptions behaves like MultiQuery, but runs with the
+// given transaction options and, if c.RetryPolicy is set, retries the
+// whole operation from scratch when it fails on a serializable
+// isolation conflict or a detected deadlock.
+func (c *Config) MultiQueryWithOptions(txOpts *sql.TxOptions, sql []string, args ...[]interface{}) ([][]map[string]interface{}, error) {
+	var ret [][]map[string]interface{}
+
+	err := c.withRetry(func() error {
+		rows, err := c.multiQuery(context.Background(), txOpts, sql, args...)
+		if err != nil {
+			return err
+		}
+		ret = rows
+		return nil
+	})
+
+	return ret, err
+}
+
+func (c *Config) multiQuery(ctx context.Context, txOpts *sql.TxOptions, sqls []string, args ...[]interface{}) ([][]map[string]interface{}, error) {
 	var ret [][]map[string]interface{}
 
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, txOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	for len(sql) > len(args) {
+	for len(sqls) > len(args) {
 		args = append(args, []interface{}{})
 	}
 
@@ -110,12 +133,17 @@ func (c *Config) MultiQuery(sql []string, args ...[]interface{}) ([][]map[string
 		}
 	}()
 
-	for i, s := range sql {
+	for i, s := range sqls {
 		if c.Driver == "postgres" {
 			s = postgresPlaceholders(s)
 		}
 
-		rows, err := tx.Query(s, args[i]...)
+		var rows *sql.Rows
+		err = c.hooked(ctx, s, args[i], func(ctx context.Context) error {
+			var queryErr error
+			rows, queryErr = tx.QueryContext(ctx, s, args[i]...)
+			return queryErr
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -159,11 +187,34 @@ func (c *Config) MultiQuery(sql []string, args ...[]interface{}) ([][]map[string
 // Upsert takes a SQL and an array list of values for upserts.
 // of rows with string map to interfaces values.
 func (c *Config) Upsert(sql string, array [][]interface{}) error {
+	return c.UpsertContext(context.Background(), sql, array)
+}
+
+// UpsertContext behaves like Upsert, but carries ctx onto the
+// underlying driver calls, so callers can cancel a slow upsert or
+// propagate a deadline.
+func (c *Config) UpsertContext(ctx context.Context, sql string, array [][]interface{}) error {
+	return c.withRetry(func() error {
+		return c.upsert(ctx, nil, sql, array)
+	})
+}
+
+// UpsertWithOptions behaves like Upsert, but runs with the given
+// transaction options and, if c.RetryPolicy is set, retries the whole
+// operation from scratch when it fails on a serializable isolation
+// conflict or a detected deadlock.
+func (c *Config) UpsertWithOptions(txOpts *sql.TxOptions, sql string, array [][]interface{}) error {
+	return c.withRetry(func() error {
+		return c.upsert(context.Background(), txOpts, sql, array)
+	})
+}
+
+func (c *Config) upsert(ctx context.Context, txOpts *sql.TxOptions, sql string, array [][]interface{}) error {
 	if c.Driver == "postgres" {
 		sql = postgresPlaceholders(sql)
 	}
 
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, txOpts)
 	if err != nil {
 		return err
 	}
@@ -182,15 +233,19 @@ func (c *Config) Upsert(sql string, array [][]interface{}) error {
 		}
 	}()
 
-	stmt, err := tx.Prepare(sql)
+	stmt, err := tx.PrepareContext(ctx, sql)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for i, a := range array {
-		if _, err := stmt.Exec(a...); err != nil {
-			return fmt.Errorf("upsert on row %d failed: %v", i, err)
+		err = c.hooked(ctx, sql, a, func(ctx context.Context) error {
+			_, err := stmt.ExecContext(ctx, a...)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("upsert on row %d failed: %w", i, err)
 		}
 	}
 
@@ -200,11 +255,34 @@ func (c *Config) Upsert(sql string, array [][]interface{}) error {
 // Transaction takes a SQL list and an array list of values for transactions.
 // of rows with string map to interfaces values.
 func (c *Config) Transaction(sqls []string, args ...[][]interface{}) error {
+	return c.TransactionContext(context.Background(), sqls, args...)
+}
+
+// TransactionContext behaves like Transaction, but carries ctx onto
+// the underlying driver calls, so callers can cancel or propagate a
+// deadline.
+func (c *Config) TransactionContext(ctx context.Context, sqls []string, args ...[][]interface{}) error {
+	return c.withRetry(func() error {
+		return c.transaction(ctx, nil, sqls, args...)
+	})
+}
+
+// TransactionWithOptions behaves like Transaction, but runs with the
+// given transaction options and, if c.RetryPolicy is set, retries the
+// whole operation from scratch when it fails on a serializable
+// isolation conflict or a detected deadlock.
+func (c *Config) TransactionWithOptions(txOpts *sql.TxOptions, sqls []string, args ...[][]interface{}) error {
+	return c.withRetry(func() error {
+		return c.transaction(context.Background(), txOpts, sqls, args...)
+	})
+}
+
+func (c *Config) transaction(ctx context.Context, txOpts *sql.TxOptions, sqls []string, args ...[][]interface{}) error {
 	for len(sqls) != len(args) {
 		return fmt.Errorf("uneven set of sql and data")
 	}
 
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, txOpts)
 	if err != nil {
 		return err
 	}
@@ -228,7 +306,7 @@ func (c *Config) Transaction(sqls []string, args ...[][]interface{}) error {
 		if c.Driver == "postgres" {
 			s = postgresPlaceholders(s)
 		}
-		stmt, err := tx.Prepare(s)
+		stmt, err := tx.PrepareContext(ctx, s)
 		if err != nil {
 			return err
 		}
@@ -238,8 +316,12 @@ func (c *Config) Transaction(sqls []string, args ...[][]interface{}) error {
 
 	for setno := range sqls {
 		for rowno, row := range args[setno] {
-			if _, err := stmtlist[setno].Exec(row...); err != nil {
-				return fmt.Errorf("sql set %d, row %d: %v", setno+1, rowno+1, err)
+			err = c.hooked(ctx, sqls[setno], row, func(ctx context.Context) error {
+				_, err := stmtlist[setno].ExecContext(ctx, row...)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("sql set %d, row %d: %w", setno+1, rowno+1, err)
 			}
 		}
 	}
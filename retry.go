@@ -0,0 +1,75 @@
+package dbi
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres SQLSTATE codes that a transaction can safely be retried
+// from scratch for.
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// RetryPolicy configures automatic retry of a transactional operation
+// that fails due to a serializable isolation conflict or a detected
+// deadlock.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times the operation is
+	// attempted before giving up. Values <= 1 disable retrying.
+	MaxAttempts int
+	// BaseDelay is the backoff used after the first failed attempt.
+	// It is doubled for every subsequent attempt.
+	BaseDelay time.Duration
+	// Jitter adds a random fraction, between 0 and Jitter, of the
+	// computed backoff on top of it, to spread out retries from
+	// concurrent callers.
+	Jitter float64
+}
+
+// isRetryableError reports whether err is a postgres serialization
+// failure or a detected deadlock.
+func isRetryableError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	switch pqErr.Code {
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+		return true
+	default:
+		return false
+	}
+}
+
+// withRetry runs fn, and, if c.RetryPolicy is set, retries it with
+// exponential backoff when it fails with isRetryableError. fn is
+// responsible for starting and rolling back its own transaction, so
+// each attempt runs from a clean slate.
+func (c *Config) withRetry(fn func() error) error {
+	policy := c.RetryPolicy
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return fn()
+	}
+
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		err = fn()
+		if err == nil || !isRetryableError(err) || attempt == policy.MaxAttempts {
+			return err
+		}
+
+		delay := policy.BaseDelay * time.Duration(uint64(1)<<uint(attempt-1))
+		if policy.Jitter > 0 {
+			delay += time.Duration(policy.Jitter * rand.Float64() * float64(delay))
+		}
+		time.Sleep(delay)
+	}
+
+	return err
+}
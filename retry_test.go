@@ -0,0 +1,137 @@
+package dbi
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// fakeRetryDriver is a minimal database/sql/driver.Driver whose
+// statements and queries always fail with a *pq.Error, used to prove
+// that upsert, transaction and unmarshal hand isRetryableError an
+// error it can still see a *pq.Error through, rather than one severed
+// by a %v-wrapped fmt.Errorf.
+type fakeRetryDriver struct{}
+
+func (fakeRetryDriver) Open(name string) (driver.Conn, error) {
+	return &fakeRetryConn{}, nil
+}
+
+type fakeRetryConn struct{}
+
+func (c *fakeRetryConn) Prepare(query string) (driver.Stmt, error) { return &fakeRetryStmt{}, nil }
+func (c *fakeRetryConn) Close() error                              { return nil }
+func (c *fakeRetryConn) Begin() (driver.Tx, error)                 { return fakeRetryTx{}, nil }
+
+func (c *fakeRetryConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return nil, &pq.Error{Code: "40001", Message: "serialization_failure"}
+}
+
+type fakeRetryStmt struct{}
+
+func (s *fakeRetryStmt) Close() error  { return nil }
+func (s *fakeRetryStmt) NumInput() int { return -1 }
+func (s *fakeRetryStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, &pq.Error{Code: "40001", Message: "serialization_failure"}
+}
+func (s *fakeRetryStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, &pq.Error{Code: "40001", Message: "serialization_failure"}
+}
+func (s *fakeRetryStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return nil, &pq.Error{Code: "40001", Message: "serialization_failure"}
+}
+
+type fakeRetryTx struct{}
+
+func (fakeRetryTx) Commit() error   { return nil }
+func (fakeRetryTx) Rollback() error { return nil }
+
+var registerFakeRetryDriverOnce sync.Once
+
+func newFakeRetryConfig(t *testing.T) *Config {
+	t.Helper()
+	registerFakeRetryDriverOnce.Do(func() {
+		sql.Register("dbi-fake-retry", fakeRetryDriver{})
+	})
+
+	db, err := sql.Open("dbi-fake-retry", "")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+
+	return &Config{Driver: "postgres", db: db}
+}
+
+// These mirror the error-wrapping review fix directly: every error
+// returned below must still satisfy errors.As(err, &pqErr) so
+// withRetry's isRetryableError can see the underlying *pq.Error.
+
+func TestUpsertPreservesRetryableError(t *testing.T) {
+	c := newFakeRetryConfig(t)
+
+	err := c.upsert(context.Background(), nil, "INSERT INTO t (id) VALUES (?)", [][]interface{}{{1}})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !isRetryableError(err) {
+		t.Fatalf("isRetryableError(%v) = false, want true", err)
+	}
+}
+
+func TestTransactionPreservesRetryableError(t *testing.T) {
+	c := newFakeRetryConfig(t)
+
+	err := c.transaction(context.Background(), nil, []string{"INSERT INTO t (id) VALUES (?)"}, [][]interface{}{{1}})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !isRetryableError(err) {
+		t.Fatalf("isRetryableError(%v) = false, want true", err)
+	}
+}
+
+type retryTestRow struct {
+	ID int `dbi:"id"`
+}
+
+func TestUnmarshalPreservesRetryableError(t *testing.T) {
+	c := newFakeRetryConfig(t)
+
+	var rows []*retryTestRow
+	err := c.unmarshal(context.Background(), nil, &rows, "SELECT id FROM t")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !isRetryableError(err) {
+		t.Fatalf("isRetryableError(%v) = false, want true", err)
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"non-pq error", fmt.Errorf("boom"), false},
+		{"unwrapped retryable serialization failure", &pq.Error{Code: sqlStateSerializationFailure}, true},
+		{"unwrapped retryable deadlock", &pq.Error{Code: sqlStateDeadlockDetected}, true},
+		{"unwrapped non-retryable code", &pq.Error{Code: "23505"}, false},
+		{"wrapped retryable", fmt.Errorf("dbi: exec: %w", &pq.Error{Code: sqlStateSerializationFailure}), true},
+		{"wrapped non-retryable", fmt.Errorf("dbi: exec: %w", &pq.Error{Code: "23505"}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableError(tt.err); got != tt.want {
+				t.Fatalf("isRetryableError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package dbi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// StringifyMode controls how QueryString renders array-valued
+// columns, such as postgres text[] or bigint[].
+type StringifyMode int
+
+const (
+	// StringifyCSV renders an array as a comma-separated list. This
+	// is the default (the zero value).
+	StringifyCSV StringifyMode = iota
+	// StringifyJSON renders an array as a JSON array.
+	StringifyJSON
+)
+
+// RegisterStringifier registers fn to render values of type t in
+// QueryString, for domain types dbi doesn't know about, such as a
+// uuid.UUID or a custom enum, without having to patch the module.
+func (c *Config) RegisterStringifier(t reflect.Type, fn func(interface{}) string) {
+	if c.stringifiers == nil {
+		c.stringifiers = make(map[reflect.Type]func(interface{}) string)
+	}
+	c.stringifiers[t] = fn
+}
+
+// stringify renders v the way QueryString does: sql.Null* types
+// unwrap to their value, or an empty string when NULL, []byte decodes
+// as UTF-8, pq array types render per c.ArrayStringifyMode, and
+// net.IP/net.HardwareAddr use their String method. A type registered
+// with RegisterStringifier takes precedence over all of the above.
+func (c *Config) stringify(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	if fn, ok := c.stringifiers[reflect.TypeOf(v)]; ok {
+		return fn(v)
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	case int64:
+		return fmt.Sprintf("%d", t)
+	case int32:
+		return fmt.Sprintf("%d", t)
+	case int16:
+		return fmt.Sprintf("%d", t)
+	case uint8:
+		return fmt.Sprintf("%c", t)
+	case float32:
+		return fmt.Sprintf("%f", t)
+	case float64:
+		return fmt.Sprintf("%f", t)
+	case bool:
+		return fmt.Sprintf("%t", t)
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	case sql.NullString:
+		if !t.Valid {
+			return ""
+		}
+		return t.String
+	case sql.NullInt64:
+		if !t.Valid {
+			return ""
+		}
+		return fmt.Sprintf("%d", t.Int64)
+	case sql.NullFloat64:
+		if !t.Valid {
+			return ""
+		}
+		return fmt.Sprintf("%f", t.Float64)
+	case sql.NullBool:
+		if !t.Valid {
+			return ""
+		}
+		return fmt.Sprintf("%t", t.Bool)
+	case sql.NullTime:
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.Format(time.RFC3339Nano)
+	case pq.NullTime:
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.Format(time.RFC3339Nano)
+	case pq.StringArray:
+		if c.ArrayStringifyMode == StringifyJSON {
+			b, err := json.Marshal([]string(t))
+			if err != nil {
+				return "[]"
+			}
+			return string(b)
+		}
+		return strings.Join(t, ",")
+	case pq.Int64Array:
+		if c.ArrayStringifyMode == StringifyJSON {
+			b, err := json.Marshal([]int64(t))
+			if err != nil {
+				return "[]"
+			}
+			return string(b)
+		}
+		elems := make([]string, len(t))
+		for i, n := range t {
+			elems[i] = fmt.Sprintf("%d", n)
+		}
+		return strings.Join(elems, ",")
+	case net.IP:
+		return t.String()
+	case net.HardwareAddr:
+		return t.String()
+	default:
+		return fmt.Sprintf("%s", v)
+	}
+}
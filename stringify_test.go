@@ -0,0 +1,88 @@
+package dbi
+
+import (
+	"database/sql"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestConfigStringify(t *testing.T) {
+	refTime := time.Date(2026, 7, 26, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"nil", nil, ""},
+		{"string", "hello", "hello"},
+		{"bytes", []byte("hello"), "hello"},
+		{"int64", int64(42), "42"},
+		{"bool", true, "true"},
+		{"time", refTime, refTime.Format(time.RFC3339Nano)},
+		{"null string valid", sql.NullString{String: "x", Valid: true}, "x"},
+		{"null string invalid", sql.NullString{}, ""},
+		{"null int64 valid", sql.NullInt64{Int64: 7, Valid: true}, "7"},
+		{"null int64 invalid", sql.NullInt64{}, ""},
+		{"null bool valid", sql.NullBool{Bool: true, Valid: true}, "true"},
+		{"null bool invalid", sql.NullBool{}, ""},
+		{"null time valid", sql.NullTime{Time: refTime, Valid: true}, refTime.Format(time.RFC3339Nano)},
+		{"null time invalid", sql.NullTime{}, ""},
+		{"pq null time valid", pq.NullTime{Time: refTime, Valid: true}, refTime.Format(time.RFC3339Nano)},
+		{"pq null time invalid", pq.NullTime{}, ""},
+		{"net.IP", net.ParseIP("192.168.0.1"), "192.168.0.1"},
+		{"net.HardwareAddr", net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0x00, 0x01}, "de:ad:be:ef:00:01"},
+	}
+
+	c := &Config{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.stringify(tt.v); got != tt.want {
+				t.Fatalf("stringify(%v) = %q, want %q", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigStringifyArrayModes(t *testing.T) {
+	c := &Config{}
+	strs := pq.StringArray{"a", "b"}
+	ints := pq.Int64Array{1, 2}
+
+	if got, want := c.stringify(strs), "a,b"; got != want {
+		t.Fatalf("CSV string array = %q, want %q", got, want)
+	}
+	if got, want := c.stringify(ints), "1,2"; got != want {
+		t.Fatalf("CSV int64 array = %q, want %q", got, want)
+	}
+
+	c.ArrayStringifyMode = StringifyJSON
+	if got, want := c.stringify(strs), `["a","b"]`; got != want {
+		t.Fatalf("JSON string array = %q, want %q", got, want)
+	}
+	if got, want := c.stringify(ints), "[1,2]"; got != want {
+		t.Fatalf("JSON int64 array = %q, want %q", got, want)
+	}
+}
+
+type customID int
+
+func TestConfigRegisterStringifier(t *testing.T) {
+	c := &Config{}
+	c.RegisterStringifier(reflect.TypeOf(customID(0)), func(v interface{}) string {
+		return "custom-" + string(rune('0'+int(v.(customID))))
+	})
+
+	if got, want := c.stringify(customID(3)), "custom-3"; got != want {
+		t.Fatalf("stringify(customID(3)) = %q, want %q", got, want)
+	}
+
+	// Unregistered types still fall through to the default switch.
+	if got, want := c.stringify("plain"), "plain"; got != want {
+		t.Fatalf("stringify(%q) = %q, want %q", "plain", got, want)
+	}
+}
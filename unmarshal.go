@@ -3,9 +3,11 @@ package dbi
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"reflect"
-	"strings"
+	"time"
 )
 
 type taggroup struct {
@@ -21,8 +23,21 @@ type taggroup struct {
 // time and time zone. To get rid of this, the unmarshaler can strip
 // away the time part if the field type is a string and the option date
 // is specified after the column name. Example. `dbi:"date,date"`
+//
+// A column scanned into a string field tagged with the hex option is
+// hex-encoded rather than copied byte for byte, useful for bytea
+// columns. Example. `dbi:"data,hex"`. A column scanned into a field
+// that isn't a plain scalar, such as a struct, map, slice or pointer,
+// is instead treated as JSON/JSONB and unmarshaled into the field.
 func (c *Config) Unmarshal(v interface{}, SQL string, args ...interface{}) error {
-	return c.unmarshal(nil, v, SQL, args...)
+	return c.UnmarshalContext(context.Background(), v, SQL, args...)
+}
+
+// UnmarshalContext behaves like Unmarshal, but carries ctx onto the
+// underlying driver calls, so callers can cancel a slow query or
+// propagate a deadline.
+func (c *Config) UnmarshalContext(ctx context.Context, v interface{}, SQL string, args ...interface{}) error {
+	return c.unmarshalWithOptions(ctx, nil, v, SQL, args...)
 }
 
 // UnmarshalReadOnly rows into a slice with pointers to a struct. The
@@ -34,7 +49,7 @@ func (c *Config) Unmarshal(v interface{}, SQL string, args ...interface{}) error
 // option date is specified after the column
 // name. Example. `dbi:"date,date"`
 func (c *Config) UnmarshalReadOnly(v interface{}, SQL string, args ...interface{}) error {
-	return c.unmarshal(&sql.TxOptions{ReadOnly: true}, v, SQL, args...)
+	return c.unmarshalWithOptions(context.Background(), &sql.TxOptions{ReadOnly: true}, v, SQL, args...)
 }
 
 // UnmarshalWithOptions rows into a slice with pointers to a struct. The
@@ -45,11 +60,30 @@ func (c *Config) UnmarshalReadOnly(v interface{}, SQL string, args ...interface{
 // can strip away the time part if the field type is a string and the
 // option date is specified after the column
 // name. Example. `dbi:"date,date"`
+//
+// If c.RetryPolicy is set, the whole query is retried from scratch
+// when it fails on a serializable isolation conflict or a detected
+// deadlock; v is reset to its pre-call state before each retry.
 func (c *Config) UnmarshalWithOptions(txOpts *sql.TxOptions, v interface{}, SQL string, args ...interface{}) error {
-	return c.unmarshal(txOpts, v, SQL, args...)
+	return c.unmarshalWithOptions(context.Background(), txOpts, v, SQL, args...)
+}
+
+func (c *Config) unmarshalWithOptions(ctx context.Context, txOpts *sql.TxOptions, v interface{}, SQL string, args ...interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return c.unmarshal(ctx, txOpts, v, SQL, args...)
+	}
+
+	slice := rv.Elem()
+	original := slice.Slice(0, slice.Len())
+
+	return c.withRetry(func() error {
+		slice.Set(original)
+		return c.unmarshal(ctx, txOpts, v, SQL, args...)
+	})
 }
 
-func (c *Config) unmarshal(txOpts *sql.TxOptions, v interface{}, SQL string, args ...interface{}) error {
+func (c *Config) unmarshal(ctx context.Context, txOpts *sql.TxOptions, v interface{}, SQL string, args ...interface{}) error {
 	var targetSlice reflect.Value
 	var t reflect.Type
 
@@ -70,29 +104,9 @@ func (c *Config) unmarshal(txOpts *sql.TxOptions, v interface{}, SQL string, arg
 	}
 
 	// Collect the fields/columns we are going to populate
-	populate := make(map[string]taggroup)
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		if f.Tag == "" {
-			continue
-		}
-		if tag, ok := f.Tag.Lookup("dbi"); ok {
-			// Column and options are separted with commas. First "option" is the column name
-			options := strings.Split(tag, ",")
-			if len(options) > 0 && options[0] != "" {
-				tag := taggroup{Field: f.Name,
-					Column:  options[0],
-					Options: make(map[string]string)}
-				for _, option := range options[1:] {
-					tag.Options[option] = ""
-				}
-
-				if _, ok := populate[tag.Column]; ok {
-					return fmt.Errorf("dbi: column %s used on multiple fields", tag.Column)
-				}
-				populate[tag.Column] = tag
-			}
-		}
+	populate, _, err := populateTags(t)
+	if err != nil {
+		return err
 	}
 
 	// Convert ? to $1 and $2 etc.
@@ -100,7 +114,7 @@ func (c *Config) unmarshal(txOpts *sql.TxOptions, v interface{}, SQL string, arg
 		SQL = postgresPlaceholders(SQL)
 	}
 
-	tx, err := c.db.BeginTx(context.Background(), txOpts)
+	tx, err := c.db.BeginTx(ctx, txOpts)
 	if err != nil {
 		return fmt.Errorf("dbi: begin: %v", err)
 	}
@@ -119,9 +133,14 @@ func (c *Config) unmarshal(txOpts *sql.TxOptions, v interface{}, SQL string, arg
 		}
 	}()
 
-	rows, err := tx.Query(SQL, args...)
+	var rows *sql.Rows
+	err = c.hooked(ctx, SQL, args, func(ctx context.Context) error {
+		var queryErr error
+		rows, queryErr = tx.QueryContext(ctx, SQL, args...)
+		return queryErr
+	})
 	if err != nil {
-		return fmt.Errorf("dbi: query: %v", err)
+		return fmt.Errorf("dbi: query: %w", err)
 	}
 
 	defer rows.Close()
@@ -139,71 +158,161 @@ func (c *Config) unmarshal(txOpts *sql.TxOptions, v interface{}, SQL string, arg
 	}
 
 	for rows.Next() {
-		columns := make([]interface{}, len(cols))
-		columnPointers := make([]interface{}, len(cols))
 		n := reflect.New(t)
 		newe := n.Elem()
 		t = newe.Type()
-		var dateOptions []int
-		var aggOptions []int
-	colLoop:
-		for i := range columns {
-			if p, ok := populate[cols[i]]; ok {
-				for j := 0; j < t.NumField(); j++ {
-					if t.Field(j).Name == p.Field &&
-						newe.Field(j).CanSet() &&
-						newe.Field(j).CanAddr() {
-						addr := newe.Field(j).Addr()
-						if addr.CanInterface() {
-							columnPointers[i] = addr.Interface()
-							if len(p.Options) > 0 {
-								if _, ok := p.Options["agg"]; ok {
-									columnPointers[i] = &columns[i]
-									aggOptions = append(aggOptions, j)
-								}
-								if _, ok := p.Options["date"]; ok && t.Field(j).Type.Name() == "string" {
-									dateOptions = append(dateOptions, j)
-								}
-							}
 
-							continue colLoop
+		if err := scanRowInto(rows, cols, populate, newe, t); err != nil {
+			return err
+		}
+
+		targetSlice.Set(reflect.Append(targetSlice, n))
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("dbi: rows: %v", err)
+	}
+
+	return err
+}
+
+// scanRowInto scans the current row of rows into newe, a value of
+// type t, mapping columns to fields via populate the same way
+// Unmarshal does: a column scanned into a string field tagged hex is
+// hex-encoded, a column scanned into a field that isn't a plain
+// scalar is treated as JSON/JSONB and unmarshaled into it, and a
+// string field tagged date is truncated to its first 10 characters.
+// A field tagged agg is not filled from its column. It is shared by Unmarshal and StructIter.Scan so both
+// honor the same tag options.
+func scanRowInto(rows *sql.Rows, cols []string, populate map[string]taggroup, newe reflect.Value, t reflect.Type) error {
+	columns := make([]interface{}, len(cols))
+	columnPointers := make([]interface{}, len(cols))
+	var dateOptions []int
+	var aggOptions []int
+	var hexOptions []scanTarget
+	var jsonOptions []scanTarget
+colLoop:
+	for i := range columns {
+		if p, ok := populate[cols[i]]; ok {
+			for j := 0; j < t.NumField(); j++ {
+				if t.Field(j).Name == p.Field &&
+					newe.Field(j).CanSet() &&
+					newe.Field(j).CanAddr() {
+					fieldType := t.Field(j).Type
+
+					if _, ok := p.Options["hex"]; ok && fieldType.Kind() == reflect.String {
+						columnPointers[i] = &columns[i]
+						hexOptions = append(hexOptions, scanTarget{col: i, field: j})
+						continue colLoop
+					}
+
+					if isJSONTarget(fieldType) {
+						columnPointers[i] = &columns[i]
+						jsonOptions = append(jsonOptions, scanTarget{col: i, field: j})
+						continue colLoop
+					}
+
+					addr := newe.Field(j).Addr()
+					if addr.CanInterface() {
+						columnPointers[i] = addr.Interface()
+						if len(p.Options) > 0 {
+							if _, ok := p.Options["agg"]; ok {
+								columnPointers[i] = &columns[i]
+								aggOptions = append(aggOptions, j)
+							}
+							if _, ok := p.Options["date"]; ok && fieldType.Name() == "string" {
+								dateOptions = append(dateOptions, j)
+							}
 						}
+
+						continue colLoop
 					}
 				}
 			}
-			// Discard column
-			columnPointers[i] = &columns[i]
 		}
+		// Discard column
+		columnPointers[i] = &columns[i]
+	}
 
-		// Scan the result into the column pointers...
-		if err := rows.Scan(columnPointers...); err != nil {
-			return fmt.Errorf("dbi: row scan: %v", err)
-		}
+	// Scan the result into the column pointers...
+	if err := rows.Scan(columnPointers...); err != nil {
+		return fmt.Errorf("dbi: row scan: %v", err)
+	}
 
-		for _, i := range dateOptions {
-			if newe.Field(i).CanSet() {
-				str := newe.Field(i).String()
-				if len(str) > 10 {
-					newe.Field(i).Set(reflect.ValueOf(str[0:10]))
-				}
+	for _, i := range dateOptions {
+		if newe.Field(i).CanSet() {
+			str := newe.Field(i).String()
+			if len(str) > 10 {
+				newe.Field(i).Set(reflect.ValueOf(str[0:10]))
 			}
 		}
+	}
 
-		for _, i := range aggOptions {
-			if newe.Field(i).CanSet() {
-				str := newe.Field(i).String()
-				if len(str) > 10 {
-					newe.Field(i).Set(reflect.ValueOf(str[0:10]))
-				}
+	for _, i := range aggOptions {
+		if newe.Field(i).CanSet() {
+			str := newe.Field(i).String()
+			if len(str) > 10 {
+				newe.Field(i).Set(reflect.ValueOf(str[0:10]))
 			}
 		}
+	}
 
-		targetSlice.Set(reflect.Append(targetSlice, n))
+	for _, st := range hexOptions {
+		if raw, ok := columnBytes(columns[st.col]); ok {
+			newe.Field(st.field).SetString(hex.EncodeToString(raw))
+		}
 	}
 
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("dbi: rows: %v", err)
+	for _, st := range jsonOptions {
+		raw, ok := columnBytes(columns[st.col])
+		if !ok || len(raw) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(raw, newe.Field(st.field).Addr().Interface()); err != nil {
+			return fmt.Errorf("dbi: json unmarshal column %s: %v", cols[st.col], err)
+		}
 	}
 
-	return err
+	return nil
+}
+
+// scanTarget pairs a scanned column index with the struct field
+// index it feeds into, for options that need the raw column bytes
+// after rows.Scan rather than a direct pointer into the field.
+type scanTarget struct {
+	col   int
+	field int
+}
+
+// isJSONTarget reports whether a struct field should be populated by
+// json-unmarshaling the raw column value into it, rather than letting
+// the driver scan directly into the field. This covers JSON/JSONB
+// columns mapped onto structs, maps, slices (other than []byte) and
+// pointers.
+func isJSONTarget(t reflect.Type) bool {
+	if t == reflect.TypeOf(time.Time{}) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Ptr:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Uint8
+	default:
+		return false
+	}
+}
+
+// columnBytes coerces a raw scanned column value, a []byte, string or
+// nil, into a byte slice.
+func columnBytes(v interface{}) ([]byte, bool) {
+	switch b := v.(type) {
+	case []byte:
+		return b, true
+	case string:
+		return []byte(b), true
+	default:
+		return nil, false
+	}
 }